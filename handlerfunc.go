@@ -24,11 +24,16 @@
 package exphttp
 
 import (
+	"context"
 	"expvar"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -48,6 +53,26 @@ var DefaultLogger = log.New(os.Stderr, "", log.LstdFlags)
 
 var expHandlers *expvar.Map
 
+// registryMu guards registry, the package-level lookup of every live
+// ExpHandler keyed by name. Exporters (e.g. exphttp/promexport) use
+// Handlers() to walk it without needing their own wiring.
+var registryMu sync.Mutex
+var registry = map[string]*ExpHandler{}
+
+// Handlers returns a snapshot of every ExpHandler created so far via
+// NewExpHandler, keyed by name. It is safe to call concurrently with
+// requests being served.
+func Handlers() map[string]*ExpHandler {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	out := make(map[string]*ExpHandler, len(registry))
+	for name, e := range registry {
+		out[name] = e
+	}
+	return out
+}
+
 // ExpHandlerFunc is a http.HandlerFunc that returns it's own HTTP StatusCode.
 type ExpHandlerFunc func(w http.ResponseWriter, r *http.Request) int
 
@@ -88,18 +113,63 @@ type ExpHandler struct {
 	// HandlerFunc is the ExpHandlerFunc that is tracked.
 	HandlerFunc ExpHandlerFunc
 
-	// Log requests to this logger if non-nil.
+	// Log requests to this logger if non-nil. Used as a StdLogger when
+	// RequestLogger is nil, so existing code that only sets Log keeps
+	// working unchanged.
 	Log *log.Logger
 
+	// RequestLogger, if set, receives a structured Entry for every request
+	// instead of the default Log-based one-liner. See StdLogger, JSONLogger
+	// and FuncLogger for ready-made implementations.
+	RequestLogger RequestLogger
+
+	// Sampler, if set, filters which requests are passed to the
+	// RequestLogger (or Log), so high-QPS handlers don't log every request.
+	Sampler *Sampler
+
+	// ReservoirSize is the number of latency samples kept per response code
+	// to compute percentiles from. Zero uses DefaultReservoirSize.
+	ReservoirSize int
+
+	// DecayHalfLife controls how quickly older latency samples lose
+	// influence over the percentiles reported for a response code. Zero
+	// uses DefaultDecayHalfLife.
+	DecayHalfLife time.Duration
+
+	// Trace enables per-request phase timing: time-to-first-byte,
+	// time-to-first-write, request body read time, and any custom phases
+	// recorded with PhaseStart/PhaseEnd. It costs an extra ResponseWriter
+	// and Body wrapper per request, so it defaults to off.
+	Trace bool
+
+	// MaxConcurrent, if non-zero, caps how many requests this handler will
+	// serve at once. Requests over the cap are rejected with 429 rather
+	// than queued, incrementing "rejected.concurrency".
+	MaxConcurrent int
+
+	// RateLimit, if set, caps the steady-state rate of requests this
+	// handler will serve. Requests over the limit are rejected with 429,
+	// incrementing "rejected.rate".
+	RateLimit *RateLimit
+
 	didInit      bool
 	reqCounters  []*RateCounter
 	respCounters []*RateCounter
+
+	inflight int64
+
+	histMu     sync.Mutex
+	histograms map[int]*Histogram
+
+	namedHistMu     sync.Mutex
+	namedHistograms map[string]*Histogram
 }
 
 // NewExpHandler creates a new ExpHandler, publishes a new expvar.Map to track
 // it, sets a default Durations={"min": time.Minute}, sets Log=DefaultLogger,
-// and adds name to the exposed "exphttp" map so that stats polling code
-// can auto-discover.
+// adds name to the exposed "exphttp" map so that stats polling code can
+// auto-discover it, and registers it so that it can be enumerated later
+// with Handlers() (used by exporters such as exphttp/promexport).
 func NewExpHandler(name string, h ExpHandlerFunc) *ExpHandler {
 	if expHandlers == nil {
 		expHandlers = expvar.NewMap("exphttp")
@@ -113,6 +183,11 @@ func NewExpHandler(name string, h ExpHandlerFunc) *ExpHandler {
 	}
 
 	expHandlers.Add(name, 1)
+
+	registryMu.Lock()
+	registry[name] = e
+	registryMu.Unlock()
+
 	return e
 }
 
@@ -128,28 +203,123 @@ func (e *ExpHandler) init() {
 		e.reqCounters = append(e.reqCounters, r1)
 		e.respCounters = append(e.respCounters, r2)
 	}
+
+	e.Stats.Set("inflight", expvar.Func(func() interface{} {
+		return atomic.LoadInt64(&e.inflight)
+	}))
+
 	e.didInit = true
 }
 
+// histogramFor returns the latency Histogram for a response code, creating
+// and publishing it into Stats (as "responses.<code>.latency_ns") the first
+// time that code is seen.
+func (e *ExpHandler) histogramFor(code int) *Histogram {
+	e.histMu.Lock()
+	defer e.histMu.Unlock()
+
+	if e.histograms == nil {
+		e.histograms = map[int]*Histogram{}
+	}
+	h, ok := e.histograms[code]
+	if !ok {
+		h = NewHistogram(e.ReservoirSize, e.DecayHalfLife)
+		e.histograms[code] = h
+		e.Stats.Set(fmt.Sprintf("responses.%d.latency_ns", code), h)
+	}
+	return h
+}
+
+// truncatedStack captures the current goroutine's stack, truncated to
+// maxStackBytes so a panicking request's log entry stays bounded.
+func truncatedStack() []byte {
+	buf := debug.Stack()
+	if len(buf) > maxStackBytes {
+		buf = buf[:maxStackBytes]
+	}
+	return buf
+}
+
+// logRequest passes entry to RequestLogger (falling back to Log, wrapped as
+// a StdLogger, for backward compatibility) unless Sampler says to drop it.
+func (e *ExpHandler) logRequest(entry Entry) {
+	if e.Sampler != nil && !e.Sampler.ShouldLog(entry) {
+		return
+	}
+
+	rl := e.RequestLogger
+	if rl == nil {
+		if e.Log == nil {
+			return
+		}
+		rl = StdLogger{e.Log}
+	}
+	rl.LogRequest(entry)
+}
+
 // ServeHTTP implements the http.Handler interface.
 func (e *ExpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if !e.didInit {
 		e.init()
 	}
 
+	n := atomic.AddInt64(&e.inflight, 1)
+	defer atomic.AddInt64(&e.inflight, -1)
+	if e.MaxConcurrent > 0 && n > int64(e.MaxConcurrent) {
+		e.Stats.Add("rejected.concurrency", 1)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many concurrent requests", http.StatusTooManyRequests)
+		return
+	}
+
+	if e.RateLimit != nil {
+		if ok, wait := e.RateLimit.allow(); !ok {
+			e.Stats.Add("rejected.rate", 1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+	}
+
 	e.Stats.Add("requests", 1)
 	for _, rc := range e.reqCounters {
 		rc.Add(1)
 	}
 
 	startTime := time.Now()
+
+	sw := newStatusWriter(w)
+	w = sw
+
+	var tw *traceWriter
+	var tb *timingBody
+	var pt *phaseTracker
+	if e.Trace {
+		tw = newTraceWriter(w, startTime)
+		w = tw
+
+		tb = &timingBody{ReadCloser: r.Body}
+		r.Body = tb
+
+		pt = newPhaseTracker()
+		r = r.WithContext(context.WithValue(r.Context(), phaseTrackerKey{}, pt))
+	}
+
 	defer func() {
 		if p := recover(); p != nil {
 			elap := time.Now().Sub(startTime).Nanoseconds()
 
-			if e.Log != nil {
-				e.Log.Println("caught panic: ", p)
-			}
+			e.logRequest(Entry{
+				Method:       r.Method,
+				URL:          r.URL.String(),
+				Status:       http.StatusInternalServerError,
+				Duration:     time.Duration(elap),
+				RemoteAddr:   r.RemoteAddr,
+				UserAgent:    r.UserAgent(),
+				BytesWritten: sw.bytes,
+				Panic:        fmt.Sprint(p),
+				Stack:        truncatedStack(),
+			})
 			e.Stats.Add("panics", 1)
 			e.Stats.Add("responses", 1)
 			for _, rc := range e.respCounters {
@@ -157,6 +327,7 @@ func (e *ExpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 			e.Stats.Add("responses.500", 1)
 			e.Stats.Add("responses.500.total_ns", elap)
+			e.histogramFor(500).Add(elap)
 
 			http.Error(w, "server error", http.StatusInternalServerError)
 		}
@@ -167,9 +338,15 @@ func (e *ExpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	////////
 	elapsed := time.Now().Sub(startTime).Nanoseconds()
-	if e.Log != nil {
-		e.Log.Println(float64(elapsed)/1000000.0, "ms --", code, "--", r.Method, r.URL)
-	}
+	e.logRequest(Entry{
+		Method:       r.Method,
+		URL:          r.URL.String(),
+		Status:       code,
+		Duration:     time.Duration(elapsed),
+		RemoteAddr:   r.RemoteAddr,
+		UserAgent:    r.UserAgent(),
+		BytesWritten: sw.bytes,
+	})
 
 	e.Stats.Add("responses", 1)
 	for _, rc := range e.respCounters {
@@ -193,4 +370,14 @@ func (e *ExpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		e.Stats.Add(fmt.Sprintf("responses.%d", code), 1)
 		e.Stats.Add(fmt.Sprintf("responses.%d.total_ns", code), elapsed)
 	}
+	e.histogramFor(code).Add(elapsed)
+
+	if e.Trace {
+		e.recordTiming(fmt.Sprintf("responses.%d.ttfb", code), tw.ttfbNs)
+		e.recordTiming(fmt.Sprintf("responses.%d.ttfw", code), tw.ttfwNs)
+		e.recordTiming("requests.body_read", tb.readNs)
+		for name, ns := range pt.durations {
+			e.recordTiming(fmt.Sprintf("phases.%s", name), ns)
+		}
+	}
 }
@@ -0,0 +1,200 @@
+package exphttp
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultReservoirSize is the number of samples a Histogram keeps around to
+// compute its percentiles from, used when ExpHandler.ReservoirSize is zero.
+const DefaultReservoirSize = 1028
+
+// DefaultDecayHalfLife is how long it takes an old sample's weight to halve
+// relative to a sample taken right now, used when ExpHandler.DecayHalfLife
+// is zero. A short half-life makes percentiles track recent traffic closely;
+// a long one smooths over bursts.
+const DefaultDecayHalfLife = 5 * time.Minute
+
+// rescaleInterval bounds how long a Histogram will go between rescaling its
+// sample priorities. Without this, priorities grow as exp(alpha*t) and will
+// eventually overflow a float64 on a long-lived handler.
+const rescaleInterval = time.Hour
+
+// histogramSample is a single observation kept in a Histogram's reservoir,
+// along with the decayed priority it was given when inserted.
+type histogramSample struct {
+	priority float64
+	value    int64
+}
+
+// sampleHeap is a min-heap of histogramSamples ordered by priority, so the
+// sample least likely to still matter is always at the root and cheap to
+// evict.
+type sampleHeap []histogramSample
+
+func (h sampleHeap) Len() int            { return len(h) }
+func (h sampleHeap) Less(i, j int) bool  { return h[i].priority < h[j].priority }
+func (h sampleHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sampleHeap) Push(x interface{}) { *h = append(*h, x.(histogramSample)) }
+func (h *sampleHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// Histogram is a streaming latency histogram backed by a forward-decaying
+// reservoir (Vitter's Algorithm R, weighted by exp(lambda*(t-t0)) as
+// described in Cormode et al.'s forward decay paper) so that percentiles
+// reflect recent traffic rather than a service's entire lifetime. It
+// implements expvar.Var so it can be published directly into an
+// ExpHandler's Stats map.
+type Histogram struct {
+	reservoirSize int
+	alpha         float64
+
+	mu          sync.Mutex
+	values      sampleHeap
+	count       int64
+	startTime   time.Time
+	nextRescale time.Time
+}
+
+// NewHistogram creates a Histogram that keeps at most reservoirSize samples,
+// decaying older samples' influence with the given half-life. A zero or
+// negative reservoirSize or halfLife falls back to DefaultReservoirSize and
+// DefaultDecayHalfLife respectively.
+func NewHistogram(reservoirSize int, halfLife time.Duration) *Histogram {
+	if reservoirSize <= 0 {
+		reservoirSize = DefaultReservoirSize
+	}
+	if halfLife <= 0 {
+		halfLife = DefaultDecayHalfLife
+	}
+
+	now := time.Now()
+	return &Histogram{
+		reservoirSize: reservoirSize,
+		alpha:         math.Ln2 / halfLife.Seconds(),
+		values:        make(sampleHeap, 0, reservoirSize),
+		startTime:     now,
+		nextRescale:   now.Add(rescaleInterval),
+	}
+}
+
+// Add records a single observation, in nanoseconds.
+func (h *Histogram) Add(ns int64) {
+	now := time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if now.After(h.nextRescale) {
+		h.rescaleLocked(now)
+	}
+
+	t := now.Sub(h.startTime).Seconds()
+	priority := math.Exp(h.alpha*t) / rand.Float64()
+	sample := histogramSample{priority: priority, value: ns}
+
+	if len(h.values) < h.reservoirSize {
+		heap.Push(&h.values, sample)
+	} else if len(h.values) > 0 && priority > h.values[0].priority {
+		h.values[0] = sample
+		heap.Fix(&h.values, 0)
+	}
+	h.count++
+}
+
+// rescaleLocked moves the epoch to now, scaling every priority down by
+// exp(-alpha*(now-oldStart)) so relative order is kept and
+// exp(alpha*t) never overflows on a long-lived histogram. Must be called
+// with h.mu held.
+func (h *Histogram) rescaleLocked(now time.Time) {
+	oldStart := h.startTime
+	h.startTime = now
+	h.nextRescale = now.Add(rescaleInterval)
+
+	factor := math.Exp(-h.alpha * now.Sub(oldStart).Seconds())
+	for i := range h.values {
+		h.values[i].priority *= factor
+	}
+}
+
+// HistogramSnapshot is a point-in-time summary of a Histogram's reservoir.
+type HistogramSnapshot struct {
+	Count int64   `json:"count"`
+	Min   int64   `json:"min"`
+	Max   int64   `json:"max"`
+	Mean  float64 `json:"mean"`
+	P50   int64   `json:"p50"`
+	P95   int64   `json:"p95"`
+	P99   int64   `json:"p99"`
+}
+
+// Snapshot returns the current min, max, mean and percentiles over the
+// samples presently in the reservoir, along with the total number of
+// observations ever added (which may exceed the reservoir size).
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	values := make([]int64, len(h.values))
+	for i, s := range h.values {
+		values[i] = s.value
+	}
+	count := h.count
+	h.mu.Unlock()
+
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	snap := HistogramSnapshot{Count: count}
+	if len(values) == 0 {
+		return snap
+	}
+
+	var sum int64
+	for _, v := range values {
+		sum += v
+	}
+
+	snap.Min = values[0]
+	snap.Max = values[len(values)-1]
+	snap.Mean = float64(sum) / float64(len(values))
+	snap.P50 = percentile(values, 0.50)
+	snap.P95 = percentile(values, 0.95)
+	snap.P99 = percentile(values, 0.99)
+	return snap
+}
+
+// percentile returns the nearest-rank value for p (0..1) from a slice
+// already sorted in ascending order.
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// String implements expvar.Var, emitting the current snapshot as JSON so it
+// can sit directly in an expvar.Map alongside plain counters.
+func (h *Histogram) String() string {
+	snap := h.Snapshot()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `{"count":%d,"min":%d,"max":%d,"mean":%g,"p50":%d,"p95":%d,"p99":%d}`,
+		snap.Count, snap.Min, snap.Max, snap.Mean, snap.P50, snap.P95, snap.P99)
+	return buf.String()
+}
@@ -0,0 +1,61 @@
+package exphttp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentiles(t *testing.T) {
+	h := NewHistogram(1000, time.Hour)
+	for i := int64(1); i <= 100; i++ {
+		h.Add(i)
+	}
+
+	snap := h.Snapshot()
+	if snap.Count != 100 {
+		t.Fatalf("Count = %d, want 100", snap.Count)
+	}
+	if snap.Min != 1 {
+		t.Fatalf("Min = %d, want 1", snap.Min)
+	}
+	if snap.Max != 100 {
+		t.Fatalf("Max = %d, want 100", snap.Max)
+	}
+	if snap.Mean != 50.5 {
+		t.Fatalf("Mean = %g, want 50.5", snap.Mean)
+	}
+	if snap.P50 != 50 {
+		t.Fatalf("P50 = %d, want 50", snap.P50)
+	}
+	if snap.P95 != 95 {
+		t.Fatalf("P95 = %d, want 95", snap.P95)
+	}
+	if snap.P99 != 99 {
+		t.Fatalf("P99 = %d, want 99", snap.P99)
+	}
+}
+
+func TestHistogramReservoirCap(t *testing.T) {
+	const reservoirSize = 10
+	h := NewHistogram(reservoirSize, time.Hour)
+	for i := int64(0); i < 1000; i++ {
+		h.Add(i)
+	}
+
+	if len(h.values) > reservoirSize {
+		t.Fatalf("reservoir holds %d samples, want <= %d", len(h.values), reservoirSize)
+	}
+
+	snap := h.Snapshot()
+	if snap.Count != 1000 {
+		t.Fatalf("Count = %d, want 1000 (total observed, independent of reservoir size)", snap.Count)
+	}
+}
+
+func TestHistogramEmptySnapshot(t *testing.T) {
+	h := NewHistogram(10, time.Hour)
+	snap := h.Snapshot()
+	if snap.Count != 0 || snap.Min != 0 || snap.Max != 0 {
+		t.Fatalf("empty histogram snapshot = %+v, want all zero", snap)
+	}
+}
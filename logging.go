@@ -0,0 +1,129 @@
+package exphttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxStackBytes bounds how much of a panic's stack trace Entry.Stack keeps,
+// so a single panicking request can't blow up an otherwise-bounded log line.
+const maxStackBytes = 4096
+
+// Entry describes a single request/response, passed to a RequestLogger once
+// the request has finished (or panicked).
+type Entry struct {
+	Method       string        `json:"method"`
+	URL          string        `json:"url"`
+	Status       int           `json:"status"`
+	Duration     time.Duration `json:"duration_ns"`
+	RemoteAddr   string        `json:"remote_addr"`
+	UserAgent    string        `json:"user_agent,omitempty"`
+	BytesWritten int64         `json:"bytes_written"`
+
+	// Panic is the recovered panic value, formatted with fmt.Sprint, or
+	// empty if the request completed normally.
+	Panic string `json:"panic,omitempty"`
+
+	// Stack is a truncated stack trace captured at the point of the panic,
+	// or nil if the request completed normally.
+	Stack []byte `json:"stack,omitempty"`
+}
+
+// RequestLogger receives one Entry per request an ExpHandler serves, after
+// any Sampler has decided it's worth logging. Implementations must be safe
+// for concurrent use, since ServeHTTP may call LogRequest from many
+// goroutines at once.
+type RequestLogger interface {
+	LogRequest(e Entry)
+}
+
+// StdLogger adapts a *log.Logger to RequestLogger, reproducing exphttp's
+// original one-line-per-request log format. It's what an ExpHandler falls
+// back to when RequestLogger is nil, using its Log field, so existing code
+// that only sets Log keeps working unchanged.
+type StdLogger struct {
+	*log.Logger
+}
+
+// LogRequest implements RequestLogger.
+func (l StdLogger) LogRequest(e Entry) {
+	if e.Panic != "" {
+		l.Println("caught panic: ", e.Panic)
+		if len(e.Stack) > 0 {
+			l.Println(string(e.Stack))
+		}
+		return
+	}
+	ms := float64(e.Duration) / float64(time.Millisecond)
+	l.Println(ms, "ms --", e.Status, "--", e.Method, e.URL)
+}
+
+// JSONLogger is a RequestLogger that writes each Entry as a single line of
+// JSON to W, suitable for feeding a log-shipping pipeline.
+type JSONLogger struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+// NewJSONLogger creates a JSONLogger writing to w.
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{W: w}
+}
+
+// LogRequest implements RequestLogger.
+func (l *JSONLogger) LogRequest(e Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := json.NewEncoder(l.W).Encode(e); err != nil {
+		fmt.Fprintln(l.W, `{"error":"exphttp: failed to encode log entry"}`)
+	}
+}
+
+// FuncLogger adapts a plain function to RequestLogger, so entries can be
+// handed to any structured logger (logrus, zap, zerolog, ...) without
+// exphttp needing to depend on it:
+//
+//     exphttp.FuncLogger(func(e exphttp.Entry) {
+//         structuredLog.Info("request", "status", e.Status, "duration", e.Duration)
+//     })
+type FuncLogger func(e Entry)
+
+// LogRequest implements RequestLogger.
+func (f FuncLogger) LogRequest(e Entry) { f(e) }
+
+// Sampler decides whether a request Entry is worth passing to an
+// ExpHandler's RequestLogger, so high-QPS services don't drown in
+// per-request lines. Non-2xx responses and anything slower than
+// SlowThreshold are always logged; everything else is logged 1/Rate of
+// the time.
+type Sampler struct {
+	// SlowThreshold requests taking at least this long are always logged,
+	// regardless of status or Rate. Zero disables this rule.
+	SlowThreshold time.Duration
+
+	// Rate logs 1 in Rate of the requests not already covered by the rules
+	// above. Zero or one logs all of them.
+	Rate uint64
+
+	n uint64
+}
+
+// ShouldLog reports whether e should be passed to the RequestLogger.
+func (s *Sampler) ShouldLog(e Entry) bool {
+	if e.Status < 200 || e.Status >= 300 {
+		return true
+	}
+	if s.SlowThreshold > 0 && e.Duration >= s.SlowThreshold {
+		return true
+	}
+	if s.Rate <= 1 {
+		return true
+	}
+	return atomic.AddUint64(&s.n, 1)%s.Rate == 0
+}
@@ -0,0 +1,172 @@
+// Package promexport exposes the stats collected by every exphttp.ExpHandler
+// in Prometheus text exposition format, so that services instrumented with
+// exphttp can be scraped by any Prometheus-compatible collector without
+// writing translation glue. It walks exphttp.Handlers() on every request, so
+// there is nothing to register up front beyond mounting the handler:
+//
+//     http.Handle("/metrics", promexport.Handler())
+//
+package promexport
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/SQLServerIO/exphttp"
+)
+
+// responseCodeRe matches the "responses.<code>" keys an ExpHandler publishes
+// in its Stats map, capturing the status code.
+var responseCodeRe = regexp.MustCompile(`^responses\.([0-9]+)$`)
+
+// totalNsRe matches the "responses.<code>.total_ns" keys, capturing the
+// status code so the cumulative time can be paired with its counter.
+var totalNsRe = regexp.MustCompile(`^responses\.([0-9]+)\.total_ns$`)
+
+// latencyRe matches the "responses.<code>.latency_ns" keys an ExpHandler
+// publishes its decaying-reservoir Histogram under, capturing the code.
+var latencyRe = regexp.MustCompile(`^responses\.([0-9]+)\.latency_ns$`)
+
+// quantiles pairs the Prometheus quantile label value with the
+// exphttp.HistogramSnapshot field it's read from.
+var quantiles = []struct {
+	label string
+	value func(exphttp.HistogramSnapshot) int64
+}{
+	{"0.5", func(s exphttp.HistogramSnapshot) int64 { return s.P50 }},
+	{"0.95", func(s exphttp.HistogramSnapshot) int64 { return s.P95 }},
+	{"0.99", func(s exphttp.HistogramSnapshot) int64 { return s.P99 }},
+}
+
+// Handler returns an http.Handler that renders the stats of every
+// exphttp.ExpHandler registered so far as Prometheus text exposition format.
+func Handler() http.Handler {
+	return http.HandlerFunc(serveMetrics)
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	handlers := exphttp.Handlers()
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(bw, "# HELP exphttp_requests_total Total requests seen by an ExpHandler.")
+	fmt.Fprintln(bw, "# TYPE exphttp_requests_total counter")
+	for _, name := range names {
+		if v := handlers[name].Stats.Get("requests"); v != nil {
+			fmt.Fprintf(bw, "exphttp_requests_total{handler=%q} %s\n", name, v.String())
+		}
+	}
+
+	fmt.Fprintln(bw, "# HELP exphttp_responses_total Total responses sent by an ExpHandler, by status code.")
+	fmt.Fprintln(bw, "# TYPE exphttp_responses_total counter")
+	for _, name := range names {
+		writeResponseCounts(bw, name, handlers[name].Stats)
+	}
+
+	fmt.Fprintln(bw, "# HELP exphttp_request_duration_seconds Request handling time, by status code.")
+	fmt.Fprintln(bw, "# TYPE exphttp_request_duration_seconds summary")
+	for _, name := range names {
+		writeDurations(bw, name, handlers[name].Stats)
+	}
+}
+
+func writeResponseCounts(w *bufio.Writer, handler string, stats *expvar.Map) {
+	type codeCount struct {
+		code  string
+		count *expvar.Int
+	}
+	var counts []codeCount
+
+	stats.Do(func(kv expvar.KeyValue) {
+		m := responseCodeRe.FindStringSubmatch(kv.Key)
+		if m == nil {
+			return
+		}
+		iv, ok := kv.Value.(*expvar.Int)
+		if !ok {
+			return
+		}
+		counts = append(counts, codeCount{code: m[1], count: iv})
+	})
+
+	sort.Slice(counts, func(i, j int) bool { return counts[i].code < counts[j].code })
+	for _, c := range counts {
+		fmt.Fprintf(w, "exphttp_responses_total{handler=%q,code=%q} %s\n", handler, c.code, c.count.String())
+	}
+}
+
+// codeDuration accumulates the response count, cumulative handling time and
+// latency quantiles for a single status code while writeDurations walks an
+// ExpHandler's Stats.
+type codeDuration struct {
+	count   int64
+	totalNs int64
+	hist    *exphttp.Histogram
+}
+
+func writeDurations(w *bufio.Writer, handler string, stats *expvar.Map) {
+	byCode := map[string]*codeDuration{}
+	entry := func(code string) *codeDuration {
+		d, ok := byCode[code]
+		if !ok {
+			d = &codeDuration{}
+			byCode[code] = d
+		}
+		return d
+	}
+
+	stats.Do(func(kv expvar.KeyValue) {
+		if m := responseCodeRe.FindStringSubmatch(kv.Key); m != nil {
+			if iv, ok := kv.Value.(*expvar.Int); ok {
+				n, _ := strconv.ParseInt(iv.String(), 10, 64)
+				entry(m[1]).count = n
+			}
+			return
+		}
+		if m := totalNsRe.FindStringSubmatch(kv.Key); m != nil {
+			if iv, ok := kv.Value.(*expvar.Int); ok {
+				n, _ := strconv.ParseInt(iv.String(), 10, 64)
+				entry(m[1]).totalNs = n
+			}
+			return
+		}
+		if m := latencyRe.FindStringSubmatch(kv.Key); m != nil {
+			if h, ok := kv.Value.(*exphttp.Histogram); ok {
+				entry(m[1]).hist = h
+			}
+		}
+	})
+
+	codes := make([]string, 0, len(byCode))
+	for code := range byCode {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	for _, code := range codes {
+		d := byCode[code]
+		if d.hist != nil {
+			snap := d.hist.Snapshot()
+			for _, q := range quantiles {
+				seconds := float64(q.value(snap)) / 1e9
+				fmt.Fprintf(w, "exphttp_request_duration_seconds{handler=%q,code=%q,quantile=%q} %g\n", handler, code, q.label, seconds)
+			}
+		}
+		sumSeconds := float64(d.totalNs) / 1e9
+		fmt.Fprintf(w, "exphttp_request_duration_seconds_sum{handler=%q,code=%q} %g\n", handler, code, sumSeconds)
+		fmt.Fprintf(w, "exphttp_request_duration_seconds_count{handler=%q,code=%q} %d\n", handler, code, d.count)
+	}
+}
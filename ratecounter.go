@@ -0,0 +1,90 @@
+package exphttp
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateCounter tracks how many events occurred within a trailing window of
+// Durations, bucketed at DefaultGranularity slices of that window so old
+// events age out without needing to track every individual timestamp. It
+// implements expvar.Var so it can be published directly into an
+// ExpHandler's Stats map, e.g. as "requests_per_min".
+type RateCounter struct {
+	interval    time.Duration
+	granularity int
+
+	mu          sync.Mutex
+	buckets     []int64
+	bucketStart time.Time
+	current     int
+}
+
+// NewRateCounter creates a RateCounter over the given trailing window,
+// bucketed at DefaultGranularity.
+func NewRateCounter(interval time.Duration) *RateCounter {
+	return &RateCounter{
+		interval:    interval,
+		granularity: DefaultGranularity,
+		buckets:     make([]int64, DefaultGranularity),
+		bucketStart: time.Now(),
+	}
+}
+
+// bucketDuration is the span of time a single bucket covers.
+func (r *RateCounter) bucketDuration() time.Duration {
+	return r.interval / time.Duration(r.granularity)
+}
+
+// rotateLocked advances the ring past any buckets that have fully aged out
+// since bucketStart, zeroing them so they don't carry stale counts into the
+// window. Must be called with r.mu held.
+func (r *RateCounter) rotateLocked(now time.Time) {
+	bd := r.bucketDuration()
+	if bd <= 0 {
+		return
+	}
+
+	steps := int(now.Sub(r.bucketStart) / bd)
+	if steps <= 0 {
+		return
+	}
+	n := steps
+	if n > r.granularity {
+		n = r.granularity
+	}
+	for i := 0; i < n; i++ {
+		r.current = (r.current + 1) % r.granularity
+		r.buckets[r.current] = 0
+	}
+	r.bucketStart = r.bucketStart.Add(time.Duration(steps) * bd)
+}
+
+// Add records n events as having happened now.
+func (r *RateCounter) Add(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rotateLocked(time.Now())
+	r.buckets[r.current] += n
+}
+
+// Sum returns the total number of events recorded within the trailing
+// window, aging out any buckets that have since expired.
+func (r *RateCounter) Sum() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rotateLocked(time.Now())
+	var total int64
+	for _, b := range r.buckets {
+		total += b
+	}
+	return total
+}
+
+// String implements expvar.Var, publishing the current window's total.
+func (r *RateCounter) String() string {
+	return strconv.FormatInt(r.Sum(), 10)
+}
@@ -0,0 +1,59 @@
+package exphttp
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimit caps the steady-state rate of requests an ExpHandler will
+// serve, using a token bucket: tokens accumulate at Rate per second, up to
+// Burst, and each request consumes one. Requests arriving when the bucket
+// is empty are rejected with 429 rather than queued.
+type RateLimit struct {
+	// Rate is how many tokens (requests) accumulate per second.
+	Rate float64
+
+	// Burst is the maximum number of tokens the bucket can hold, i.e. the
+	// largest burst of requests let through back-to-back. Zero or negative
+	// is treated as 1 (no bursting beyond the steady-state Rate).
+	Burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// allow consumes a token if one is available, reporting true. Otherwise it
+// reports false along with how long the caller should wait before a token
+// is next available, for use in a Retry-After header.
+func (rl *RateLimit) allow() (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	burst := rl.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+
+	now := time.Now()
+	if rl.last.IsZero() {
+		rl.tokens = float64(burst)
+	} else {
+		rl.tokens += now.Sub(rl.last).Seconds() * rl.Rate
+		if max := float64(burst); rl.tokens > max {
+			rl.tokens = max
+		}
+	}
+	rl.last = now
+
+	if rl.tokens >= 1 {
+		rl.tokens--
+		return true, 0
+	}
+
+	var wait time.Duration
+	if rl.Rate > 0 {
+		wait = time.Duration((1 - rl.tokens) / rl.Rate * float64(time.Second))
+	}
+	return false, wait
+}
@@ -0,0 +1,54 @@
+package exphttp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitAllowsUpToBurst(t *testing.T) {
+	rl := &RateLimit{Rate: 1, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := rl.allow(); !ok {
+			t.Fatalf("request %d: allow() = false, want true (within burst)", i)
+		}
+	}
+
+	ok, wait := rl.allow()
+	if ok {
+		t.Fatal("allow() = true after burst exhausted, want false")
+	}
+	if wait <= 0 {
+		t.Fatalf("wait = %v, want > 0 once the bucket is empty", wait)
+	}
+}
+
+func TestRateLimitRefillsOverTime(t *testing.T) {
+	rl := &RateLimit{Rate: 10, Burst: 1}
+
+	if ok, _ := rl.allow(); !ok {
+		t.Fatal("first allow() = false, want true")
+	}
+	if ok, _ := rl.allow(); ok {
+		t.Fatal("second allow() = true immediately after exhausting burst, want false")
+	}
+
+	// Simulate the passage of time a token should refill in, rather than
+	// sleeping the test.
+	rl.last = rl.last.Add(-200 * time.Millisecond)
+
+	if ok, _ := rl.allow(); !ok {
+		t.Fatal("allow() = false after enough time for a refill, want true")
+	}
+}
+
+func TestRateLimitZeroBurstDefaultsToOne(t *testing.T) {
+	rl := &RateLimit{Rate: 1}
+
+	if ok, _ := rl.allow(); !ok {
+		t.Fatal("first allow() = false, want true (zero Burst should behave as 1)")
+	}
+	if ok, _ := rl.allow(); ok {
+		t.Fatal("second allow() = true back-to-back with Burst defaulting to 1, want false")
+	}
+}
@@ -0,0 +1,107 @@
+package snapshot
+
+import "net/http"
+
+// serveGraph renders a single self-contained HTML page (inline CSS and JS,
+// no external dependencies) that lets an operator pick a handler and window
+// and see request rate, error rate, and latency percentiles plotted from
+// the JSON the same Collector serves at "history".
+func serveGraph(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(graphHTML))
+}
+
+const graphHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>exphttp history</title>
+<style>
+  body { font: 14px sans-serif; margin: 1.5em; color: #222; }
+  select { font: inherit; margin-right: 1em; }
+  canvas { border: 1px solid #ccc; display: block; margin-top: 1em; }
+  .legend span { margin-right: 1.5em; }
+  .rate { color: #2a6; }
+  .err { color: #c33; }
+  .p95 { color: #36c; }
+</style>
+</head>
+<body>
+<h1>exphttp history</h1>
+<label>handler <select id="handler"></select></label>
+<label>window
+  <select id="window">
+    <option value="5min">5min</option>
+    <option value="hour" selected>hour</option>
+    <option value="day">day</option>
+  </select>
+</label>
+<div class="legend">
+  <span class="rate">&mdash; requests/sample</span>
+  <span class="err">&mdash; errors/sample</span>
+  <span class="p95">&mdash; p95 latency</span>
+</div>
+<canvas id="chart" width="900" height="300"></canvas>
+
+<script>
+var handlerSel = document.getElementById('handler');
+var windowSel = document.getElementById('window');
+var canvas = document.getElementById('chart');
+var ctx = canvas.getContext('2d');
+
+function loadHandlers() {
+  fetch('handlers').then(function(r) { return r.json(); }).then(function(names) {
+    handlerSel.innerHTML = '';
+    names.forEach(function(n) {
+      var o = document.createElement('option');
+      o.value = n; o.textContent = n;
+      handlerSel.appendChild(o);
+    });
+    if (names.length > 0) { refresh(); }
+  });
+}
+
+function refresh() {
+  var name = handlerSel.value;
+  if (!name) { return; }
+  var url = 'history?name=' + encodeURIComponent(name) + '&window=' + encodeURIComponent(windowSel.value);
+  fetch(url).then(function(r) { return r.json(); }).then(draw);
+}
+
+function draw(samples) {
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  if (!samples || samples.length === 0) { return; }
+
+  var maxRate = 1, maxLatency = 1;
+  samples.forEach(function(s) {
+    maxRate = Math.max(maxRate, s.requests, s.errors);
+    maxLatency = Math.max(maxLatency, s.p95_ns);
+  });
+
+  var w = canvas.width, h = canvas.height;
+  var stepX = samples.length > 1 ? w / (samples.length - 1) : w;
+
+  function plot(key, maxVal, color) {
+    ctx.strokeStyle = color;
+    ctx.beginPath();
+    samples.forEach(function(s, i) {
+      var x = i * stepX;
+      var y = h - (s[key] / maxVal) * h;
+      if (i === 0) { ctx.moveTo(x, y); } else { ctx.lineTo(x, y); }
+    });
+    ctx.stroke();
+  }
+
+  plot('requests', maxRate, '#2a6');
+  plot('errors', maxRate, '#c33');
+  plot('p95_ns', maxLatency, '#36c');
+}
+
+handlerSel.addEventListener('change', refresh);
+windowSel.addEventListener('change', refresh);
+loadHandlers();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`
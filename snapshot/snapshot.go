@@ -0,0 +1,301 @@
+// Package snapshot turns exphttp's point-in-time expvar dump into rolling
+// time series, so operators can watch a handler's traffic and latency
+// change over time instead of only seeing the current totals.
+//
+// A Collector periodically samples every exphttp.ExpHandler registered via
+// exphttp.Handlers() into a few rolling windows of different resolution,
+// and serves the results as JSON (for your own graphing) or as a
+// self-contained HTML page (for a quick look without one):
+//
+//     snap := snapshot.NewCollector()
+//     snap.Start()
+//     http.Handle("/debug/exphttp/", http.StripPrefix("/debug/exphttp", snap.Handler()))
+//
+// This mounts "/debug/exphttp/history?name=<handler>&window=hour" and
+// "/debug/exphttp/graph".
+package snapshot
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SQLServerIO/exphttp"
+)
+
+// Sample is one point-in-time observation of an ExpHandler's stats, as
+// stored in a window's ring buffer. Requests, Responses and Errors are
+// counts accumulated since the previous sample in the same window, not
+// running totals, so they can be plotted directly as a rate.
+type Sample struct {
+	TS        int64 `json:"ts"`
+	Requests  int64 `json:"requests"`
+	Responses int64 `json:"responses"`
+	Errors    int64 `json:"errors"`
+	P50Ns     int64 `json:"p50_ns"`
+	P95Ns     int64 `json:"p95_ns"`
+	P99Ns     int64 `json:"p99_ns"`
+}
+
+// window describes one rolling time series: how often it's sampled and how
+// many samples it keeps. The two together determine the span it covers.
+type window struct {
+	name     string
+	interval time.Duration
+	capacity int
+}
+
+// windows are the fixed set of resolutions a Collector maintains per
+// handler: the last 5 minutes at 1s resolution, the last hour at 15s, and
+// the last day at 5min.
+var windows = []window{
+	{name: "5min", interval: time.Second, capacity: 300},
+	{name: "hour", interval: 15 * time.Second, capacity: 240},
+	{name: "day", interval: 5 * time.Minute, capacity: 288},
+}
+
+// ring is a fixed-capacity circular buffer of Samples, oldest overwritten
+// first once full.
+type ring struct {
+	mu     sync.Mutex
+	buf    []Sample
+	next   int
+	filled bool
+}
+
+func newRing(capacity int) *ring {
+	return &ring{buf: make([]Sample, capacity)}
+}
+
+func (r *ring) add(s Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.buf[r.next] = s
+	r.next = (r.next + 1) % len(r.buf)
+	if r.next == 0 {
+		r.filled = true
+	}
+}
+
+// snapshot returns the samples currently in the ring, oldest first.
+func (r *ring) snapshot() []Sample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.filled {
+		out := make([]Sample, r.next)
+		copy(out, r.buf[:r.next])
+		return out
+	}
+	out := make([]Sample, len(r.buf))
+	n := copy(out, r.buf[r.next:])
+	copy(out[n:], r.buf[:r.next])
+	return out
+}
+
+// handlerWindow is the sampling state a Collector keeps for a single
+// (handler, window) pair: its ring of past samples, plus the running
+// totals needed to turn the next sample's cumulative counters into a
+// per-interval delta.
+type handlerWindow struct {
+	ring     *ring
+	haveLast bool
+	lastReq  int64
+	lastResp int64
+	lastErr  int64
+}
+
+// Collector periodically samples every exphttp.ExpHandler's stats into a
+// set of rolling windows, and serves them as JSON or an HTML graph. The
+// zero value is not usable; create one with NewCollector.
+type Collector struct {
+	mu     sync.Mutex
+	states map[string]map[string]*handlerWindow // handler name -> window name -> state
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewCollector creates a Collector. Call Start to begin sampling.
+func NewCollector() *Collector {
+	return &Collector{
+		states: map[string]map[string]*handlerWindow{},
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start begins sampling every registered exphttp.ExpHandler, one goroutine
+// per window resolution, until Stop is called.
+func (c *Collector) Start() {
+	for _, w := range windows {
+		c.wg.Add(1)
+		go c.run(w)
+	}
+}
+
+// Stop halts sampling and waits for it to finish. A Collector cannot be
+// restarted after Stop.
+func (c *Collector) Stop() {
+	close(c.stopCh)
+	c.wg.Wait()
+}
+
+func (c *Collector) run(w window) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			c.sampleAll(w)
+		}
+	}
+}
+
+func (c *Collector) sampleAll(w window) {
+	now := time.Now().Unix()
+	for name, h := range exphttp.Handlers() {
+		requests, responses, errors, p50, p95, p99 := readStats(h.Stats)
+
+		st := c.stateFor(name, w)
+		var dReq, dResp, dErr int64
+		if st.haveLast {
+			dReq = requests - st.lastReq
+			dResp = responses - st.lastResp
+			dErr = errors - st.lastErr
+		}
+		st.haveLast = true
+		st.lastReq, st.lastResp, st.lastErr = requests, responses, errors
+
+		st.ring.add(Sample{
+			TS:        now,
+			Requests:  dReq,
+			Responses: dResp,
+			Errors:    dErr,
+			P50Ns:     p50,
+			P95Ns:     p95,
+			P99Ns:     p99,
+		})
+	}
+}
+
+func (c *Collector) stateFor(handler string, w window) *handlerWindow {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	byWindow, ok := c.states[handler]
+	if !ok {
+		byWindow = map[string]*handlerWindow{}
+		c.states[handler] = byWindow
+	}
+	st, ok := byWindow[w.name]
+	if !ok {
+		st = &handlerWindow{ring: newRing(w.capacity)}
+		byWindow[w.name] = st
+	}
+	return st
+}
+
+var (
+	responseCodeRe = regexp.MustCompile(`^responses\.([0-9]+)$`)
+	latencyRe      = regexp.MustCompile(`^responses\.([0-9]+)\.latency_ns$`)
+)
+
+// readStats pulls the cumulative request/response/error counts and an
+// overall latency percentile out of an ExpHandler's Stats map. The overall
+// percentile is taken from whichever response code's histogram currently
+// has the most samples, as a simple proxy for "the latency most requests
+// actually see" rather than averaging percentiles across codes, which
+// isn't meaningful.
+func readStats(stats *expvar.Map) (requests, responses, errors, p50, p95, p99 int64) {
+	if v, ok := stats.Get("requests").(*expvar.Int); ok {
+		requests = v.Value()
+	}
+	if v, ok := stats.Get("responses").(*expvar.Int); ok {
+		responses = v.Value()
+	}
+
+	var bestCount int64
+	stats.Do(func(kv expvar.KeyValue) {
+		if m := responseCodeRe.FindStringSubmatch(kv.Key); m != nil {
+			iv, ok := kv.Value.(*expvar.Int)
+			if !ok {
+				return
+			}
+			if code, _ := strconv.Atoi(m[1]); code >= 400 {
+				errors += iv.Value()
+			}
+			return
+		}
+
+		if !latencyRe.MatchString(kv.Key) {
+			return
+		}
+		h, ok := kv.Value.(*exphttp.Histogram)
+		if !ok {
+			return
+		}
+		snap := h.Snapshot()
+		if snap.Count > bestCount {
+			bestCount = snap.Count
+			p50, p95, p99 = snap.P50, snap.P95, snap.P99
+		}
+	})
+	return
+}
+
+// Handler returns an http.Handler serving the JSON history endpoint, the
+// handler-name listing it's driven from, and the built-in graph renderer.
+// Mount it under a prefix with http.StripPrefix, keeping the leading "/" on
+// the stripped path, e.g.:
+//
+//     http.Handle("/debug/exphttp/", http.StripPrefix("/debug/exphttp", snap.Handler()))
+func (c *Collector) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/history", c.serveHistory)
+	mux.HandleFunc("/handlers", c.serveHandlers)
+	mux.HandleFunc("/graph", serveGraph)
+	return mux
+}
+
+func (c *Collector) serveHistory(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	windowName := r.URL.Query().Get("window")
+	if windowName == "" {
+		windowName = "5min"
+	}
+
+	c.mu.Lock()
+	st, ok := c.states[name][windowName]
+	c.mu.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("exphttp/snapshot: no samples for handler %q, window %q", name, windowName), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st.ring.snapshot())
+}
+
+func (c *Collector) serveHandlers(w http.ResponseWriter, r *http.Request) {
+	handlers := exphttp.Handlers()
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(names)
+}
@@ -0,0 +1,162 @@
+package exphttp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// traceWriter wraps an http.ResponseWriter, recording how long it took the
+// handler to reach its first WriteHeader (time-to-first-byte) and its
+// first Write (time-to-first-write), relative to start. It forwards
+// Hijacker, Flusher and Pusher so enabling ExpHandler.Trace doesn't change
+// a handler's behavior, only what gets measured.
+type traceWriter struct {
+	http.ResponseWriter
+	start time.Time
+
+	wroteHeader bool
+	ttfbNs      int64
+
+	wroteBody bool
+	ttfwNs    int64
+}
+
+func newTraceWriter(w http.ResponseWriter, start time.Time) *traceWriter {
+	return &traceWriter{ResponseWriter: w, start: start}
+}
+
+func (w *traceWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.ttfbNs = time.Since(w.start).Nanoseconds()
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *traceWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.wroteBody {
+		w.ttfwNs = time.Since(w.start).Nanoseconds()
+		w.wroteBody = true
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *traceWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("exphttp: underlying ResponseWriter does not implement http.Hijacker")
+	}
+	return hj.Hijack()
+}
+
+func (w *traceWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *traceWriter) Push(target string, opts *http.PushOptions) error {
+	p, ok := w.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
+
+// timingBody wraps an http.Request's Body, accumulating the total time
+// spent inside Read so ServeHTTP can report how long a handler waited on
+// the request body.
+type timingBody struct {
+	io.ReadCloser
+	readNs int64
+}
+
+func (b *timingBody) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := b.ReadCloser.Read(p)
+	b.readNs += time.Since(start).Nanoseconds()
+	return n, err
+}
+
+// phaseTracker accumulates the durations of custom phases started and
+// stopped with PhaseStart/PhaseEnd over the lifetime of a single request.
+type phaseTracker struct {
+	mu        sync.Mutex
+	active    map[string]time.Time
+	durations map[string]int64
+}
+
+func newPhaseTracker() *phaseTracker {
+	return &phaseTracker{
+		active:    map[string]time.Time{},
+		durations: map[string]int64{},
+	}
+}
+
+type phaseTrackerKey struct{}
+
+// PhaseStart marks the beginning of a named custom phase (e.g. "db",
+// "cache") within a request handled by an ExpHandler with Trace enabled.
+// It is a no-op if ctx didn't come from such a request, so it's always
+// safe to call.
+func PhaseStart(ctx context.Context, name string) {
+	pt, ok := ctx.Value(phaseTrackerKey{}).(*phaseTracker)
+	if !ok {
+		return
+	}
+	pt.mu.Lock()
+	pt.active[name] = time.Now()
+	pt.mu.Unlock()
+}
+
+// PhaseEnd marks the end of a named custom phase started with PhaseStart.
+// Its duration is aggregated into the owning ExpHandler's Stats as
+// "phases.<name>_ns" (plus a "phases.<name>_ns_hist" histogram) once the request
+// finishes. It is a no-op if name was never started, or ctx didn't come
+// from a traced request.
+func PhaseEnd(ctx context.Context, name string) {
+	pt, ok := ctx.Value(phaseTrackerKey{}).(*phaseTracker)
+	if !ok {
+		return
+	}
+	pt.mu.Lock()
+	if start, ok := pt.active[name]; ok {
+		pt.durations[name] += time.Since(start).Nanoseconds()
+		delete(pt.active, name)
+	}
+	pt.mu.Unlock()
+}
+
+// namedHistogramFor returns the Histogram published at key, creating and
+// publishing it into Stats the first time key is seen.
+func (e *ExpHandler) namedHistogramFor(key string) *Histogram {
+	e.namedHistMu.Lock()
+	defer e.namedHistMu.Unlock()
+
+	if e.namedHistograms == nil {
+		e.namedHistograms = map[string]*Histogram{}
+	}
+	h, ok := e.namedHistograms[key]
+	if !ok {
+		h = NewHistogram(e.ReservoirSize, e.DecayHalfLife)
+		e.namedHistograms[key] = h
+		e.Stats.Set(key, h)
+	}
+	return h
+}
+
+// recordTiming adds ns to the cumulative "<base>_ns" counter and to the
+// "<base>_ns_hist" latency histogram.
+func (e *ExpHandler) recordTiming(base string, ns int64) {
+	e.Stats.Add(base+"_ns", ns)
+	e.namedHistogramFor(base + "_ns_hist").Add(ns)
+}
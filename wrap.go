@@ -0,0 +1,120 @@
+package exphttp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// statusWriter wraps an http.ResponseWriter to capture the status code and
+// byte count of a response without requiring the wrapped handler to change
+// its signature. It forwards Hijacker, Flusher and Pusher to the underlying
+// ResponseWriter when it implements them, so retrofitting Wrap onto an
+// existing handler doesn't silently break websockets, streaming responses,
+// or HTTP/2 push.
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	bytes       int64
+	wroteHeader bool
+}
+
+func newStatusWriter(w http.ResponseWriter) *statusWriter {
+	return &statusWriter{ResponseWriter: w}
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// status returns the response's status code, defaulting to 200 per
+// net/http's own convention if the handler never called WriteHeader.
+func (w *statusWriter) status() int {
+	if !w.wroteHeader {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("exphttp: underlying ResponseWriter does not implement http.Hijacker")
+	}
+	return hj.Hijack()
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Push(target string, opts *http.PushOptions) error {
+	p, ok := w.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
+
+// Wrap retrofits stats tracking onto an existing http.Handler (including
+// http.DefaultServeMux, or a third-party router) without requiring it to be
+// rewritten as an ExpHandlerFunc. The returned handler is itself an
+// *ExpHandler registered under name, so it shows up in Handlers() and any
+// exporter built on top of it (e.g. exphttp/promexport) same as any other.
+func Wrap(name string, h http.Handler) http.Handler {
+	e := NewExpHandler(name, nil)
+	e.HandlerFunc = func(w http.ResponseWriter, r *http.Request) int {
+		sw := newStatusWriter(w)
+		h.ServeHTTP(sw, r)
+		e.Stats.Add(fmt.Sprintf("responses.%d.bytes", sw.status()), sw.bytes)
+		return sw.status()
+	}
+	return e
+}
+
+// WrapMux wraps every route already registered on mux so each gets its own
+// ExpHandler, deriving the stats name from prefix plus the pattern the
+// route was registered under (e.g. prefix "api" and pattern "/users/"
+// becomes "api./users/"). Per-route handlers are created lazily, the first
+// time each pattern is actually hit, and cached from then on; this relies
+// only on ServeMux's exported Handler lookup rather than reaching into its
+// unexported internals, so it keeps working across Go versions.
+func WrapMux(prefix string, mux *http.ServeMux) http.Handler {
+	var mu sync.Mutex
+	wrapped := map[string]http.Handler{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		mu.Lock()
+		wh, ok := wrapped[pattern]
+		if !ok {
+			wh = Wrap(prefix+"."+pattern, h)
+			wrapped[pattern] = wh
+		}
+		mu.Unlock()
+
+		wh.ServeHTTP(w, r)
+	})
+}